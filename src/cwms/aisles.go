@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"time"
 	"net/http"
+	"strconv"
 	"strings"
 	"encoding/json"
+	"encoding/xml"
 )
 
+// defaultQueryTimeout bounds how long an inventory query may run when the
+// caller does not request a different deadline via the X-Request-Deadline
+// header.
+const defaultQueryTimeout = 5 * time.Second
+
 // Wms is Warehouse Management System inventory database record structure that matches the fields in the v_inventory view
 // 	xml reflection tags are included for xml marshalling
 type Wms struct {
@@ -32,10 +40,13 @@ type NullString struct {
 }
 
 
-// MarshalJSON for NullString
+// MarshalJSON for NullString. Emits "" rather than null for backward
+// compatibility with existing clients; callers that want a real JSON null
+// for SQL NULLs can request it with ?nullstyle=json, which routes through
+// wmsJSON/toNullableJSON in encoding.go instead of this method.
 func (ns *NullString) MarshalJSON() ([]byte, error) {
 	if !ns.Valid {
-		return []byte("\"\""), nil //TODO this is dumb, should be []byte("null")
+		return []byte("\"\""), nil
 	}
 	return json.Marshal(ns.String)
 }
@@ -48,22 +59,45 @@ func (ns *NullString) UnmarshalJSON(b []byte) error {
 }
 
 
-// MarshalCSV for NullString
-func (ns *NullString) MarshalCSV() ([]byte, error) {
+// MarshalCSV for NullString. The signature must match gocsv's
+// TypeMarshaller interface (MarshalCSV() (string, error)) or gocsv falls
+// back to reflecting over the embedded sql.NullString instead of calling
+// this method.
+func (ns *NullString) MarshalCSV() (string, error) {
+	if !ns.Valid {
+		return "", nil
+	}
+	return ns.String, nil
+}
+
+// MarshalXML for NullString, so empty SQL nulls round-trip consistently
+// across encoders instead of only being handled for JSON and CSV.
+func (ns *NullString) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if !ns.Valid {
+		return e.EncodeElement("", start)
+	}
+	return e.EncodeElement(ns.String, start)
+}
+
+// MarshalYAML for NullString, so empty SQL nulls round-trip consistently
+// across encoders instead of only being handled for JSON and CSV.
+func (ns NullString) MarshalYAML() (interface{}, error) {
 	if !ns.Valid {
-		return []byte(""), nil //TODO this is dumb, should be []byte("null")
+		return nil, nil
 	}
-	return []byte(ns.String), nil
+	return ns.String, nil
 }
 
 // WmsList is a slice of Wms
 type WmsList []Wms
 
 // FetchInventory performs a query on v_inventory and returns the results in a WmsList.
-func FetchInventory(af AisleFilter) (wl WmsList, err error) {
+// The query is cancelled if ctx is done before it completes.
+func FetchInventory(ctx context.Context, af AisleFilter) (wl WmsList, err error) {
 	// Execute database query
 	var rows *sql.Rows
-	rows, err = db.Query(af.toSqlStmt())
+	stmt, args := af.toSqlStmt()
+	rows, err = db.QueryContext(ctx, stmt, args...)
 
 	if err != nil {
 		return
@@ -83,10 +117,11 @@ func FetchInventory(af AisleFilter) (wl WmsList, err error) {
 }
 
 // fetchAisles performs a query on v_inventory and returns the results in a aisleList
-func fetchAisles(filter string) (aisleList []string, err error) {
+// The query is cancelled if ctx is done before it completes.
+func fetchAisles(ctx context.Context, filter string) (aisleList []string, err error) {
 	// Execute database query
 	var rows *sql.Rows
-	rows, err = db.Query(`select distinct aisle from v_inventory order by aisle`)
+	rows, err = db.QueryContext(ctx, `select distinct aisle from v_inventory order by aisle`)
 	if err != nil {
 		return
 	}
@@ -105,35 +140,171 @@ func fetchAisles(filter string) (aisleList []string, err error) {
 }
 
 // AisleFilter holds Aisle filter information
-// Aisle and Discrepancy filters a cumulative
+// Aisle, Discrepancy, SkuPrefix, Block, SlotMin/SlotMax and
+// ScannedAfter/ScannedBefore filters are cumulative
 type AisleFilter struct {
-	Aisle       string // Filter on Aisle
-	Discrepancy string // Filter on Discrepancies
+	Aisle         string    // Filter on Aisle
+	Discrepancy   string    // Filter on Discrepancies
+	SkuPrefix     string    // Filter on SKU prefix
+	Block         string    // Filter on Block
+	SlotMin       string    // Filter on minimum Slot (inclusive)
+	SlotMax       string    // Filter on maximum Slot (inclusive)
+	ScannedAfter  time.Time // Filter on rows last scanned at or after this time
+	ScannedBefore time.Time // Filter on rows last scanned at or before this time
+	Limit         int       // Maximum number of rows to return, 0 means no limit
+	Offset        int       // Number of rows to skip, used together with Limit
+	SortDesc      bool      // Sort descending instead of the default ascending order
+	AfterAisle    string    // Keyset pagination: only rows after this (aisle,block,slot) tuple
+	AfterBlock    string    // Keyset pagination: paired with AfterAisle and AfterSlot
+	AfterSlot     string    // Keyset pagination: paired with AfterAisle and AfterBlock
 }
 
-// toSqlStmt generates a sql statement
-func (af AisleFilter) toSqlStmt() (sqlstmt string) {
+// escapeLikePattern escapes the LIKE wildcard characters % and _, and the
+// escape character \ itself, so a SkuPrefix value is matched literally
+// instead of being interpreted as a wildcard pattern.
+func escapeLikePattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// toSqlStmt generates a parameterized sql statement and its placeholder
+// arguments, so caller-supplied values never get interpolated into the query
+// text.
+func (af AisleFilter) toSqlStmt() (sqlstmt string, args []interface{}) {
 	var sel, order string
 	var where []string
 	sel = `select inventoryId, startTime, stopTime, sku, aisle, block, slot, shelf, displayName, discrepancy, imageUrl from v_inventory `
 	if af.Aisle != "" {
-		where = append(where, fmt.Sprintf(`aisle ='%s'`, af.Aisle))
+		where = append(where, `aisle = ?`)
+		args = append(args, af.Aisle)
 	}
 	if af.Discrepancy == "all" {
-		where = append(where, `discrepancy !="" `)
+		where = append(where, `discrepancy != "" `)
 	} else if af.Discrepancy != "" {
-		where = append(where, fmt.Sprintf(`discrepancy ='%s'`, af.Discrepancy))
+		where = append(where, `discrepancy = ?`)
+		args = append(args, af.Discrepancy)
+	}
+	if af.SkuPrefix != "" {
+		where = append(where, `sku like ? escape '\\'`)
+		args = append(args, escapeLikePattern(af.SkuPrefix)+"%")
+	}
+	if af.Block != "" {
+		where = append(where, `block = ?`)
+		args = append(args, af.Block)
+	}
+	if af.SlotMin != "" {
+		where = append(where, `slot >= ?`)
+		args = append(args, af.SlotMin)
+	}
+	if af.SlotMax != "" {
+		where = append(where, `slot <= ?`)
+		args = append(args, af.SlotMax)
+	}
+	if !af.ScannedAfter.IsZero() {
+		where = append(where, `stopTime >= ?`)
+		args = append(args, af.ScannedAfter)
+	}
+	if !af.ScannedBefore.IsZero() {
+		where = append(where, `stopTime <= ?`)
+		args = append(args, af.ScannedBefore)
+	}
+	if af.AfterAisle != "" || af.AfterBlock != "" || af.AfterSlot != "" {
+		cmp := ">"
+		if af.SortDesc {
+			cmp = "<"
+		}
+		where = append(where, fmt.Sprintf(`(aisle, block, slot) %s (?, ?, ?)`, cmp))
+		args = append(args, af.AfterAisle, af.AfterBlock, af.AfterSlot)
+	}
+
+	if af.SortDesc {
+		order = `order by aisle desc, block desc, slot desc`
+	} else {
+		order = `order by aisle, block, slot`
 	}
-	order = `order by aisle, block, slot`
 	if len(where) > 0 {
 		sqlstmt = fmt.Sprintf("%s where %s %s", sel, strings.Join(where, " and "), order)
 	} else {
 		sqlstmt = fmt.Sprintf("%s %s", sel, order)
 	}
+
+	if af.Limit > 0 {
+		sqlstmt += " limit ?"
+		args = append(args, af.Limit)
+		if af.Offset > 0 {
+			sqlstmt += " offset ?"
+			args = append(args, af.Offset)
+		}
+	}
 	return
 }
 
-func handleApiAisles(w http.ResponseWriter, r *http.Request) {
+// applyQueryFilters augments af with the additional predicates carried in the
+// request's query-string parameters: skuPrefix, block, slotMin, slotMax,
+// scannedAfter/scannedBefore (RFC3339), limit, offset and sort (asc|desc).
+func applyQueryFilters(af *AisleFilter, r *http.Request) {
+	q := r.URL.Query()
+	if v := q.Get("skuPrefix"); v != "" {
+		af.SkuPrefix = v
+	}
+	if v := q.Get("block"); v != "" {
+		af.Block = v
+	}
+	if v := q.Get("slotMin"); v != "" {
+		af.SlotMin = v
+	}
+	if v := q.Get("slotMax"); v != "" {
+		af.SlotMax = v
+	}
+	if v := q.Get("scannedAfter"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			af.ScannedAfter = t
+		}
+	}
+	if v := q.Get("scannedBefore"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			af.ScannedBefore = t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			af.Limit = n
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			af.Offset = n
+		}
+	}
+	if q.Get("sort") == "desc" {
+		af.SortDesc = true
+	}
+}
+
+// withRequestDeadline wraps next with a context deadline derived from the
+// request, so an in-flight v_inventory query gets cancelled at the driver
+// level instead of holding a goroutine and a connection indefinitely after
+// the client disconnects. Callers may override defaultQueryTimeout via an
+// X-Request-Deadline header set to a duration (e.g. "2s").
+func withRequestDeadline(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := defaultQueryTimeout
+		if v := r.Header.Get("X-Request-Deadline"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				timeout = d
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// handleApiAisles is wrapped with withRequestDeadline so a request-scoped
+// timeout actually bounds the underlying v_inventory query.
+var handleApiAisles = withRequestDeadline(handleApiAislesImpl)
+
+func handleApiAislesImpl(w http.ResponseWriter, r *http.Request) {
 	// Fetch inventory based on page controls
 	var af AisleFilter
 
@@ -147,28 +318,34 @@ func handleApiAisles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if af.Aisle == "" {
-		asl, err := fetchAisleStats()
+		asl, err := fetchAisleStats(r.Context())
 		if err != nil {
 			log.Println(err)
 		}
-		// Send filtered inventory in json response
-		if err = jsonApi(w, r, asl, false); err != nil {
+		// Send filtered inventory in the requested response format
+		if err = writeResponse(w, r, asl, false); err != nil {
 			log.Println(err)
 		}
 	} else {
+		applyQueryFilters(&af, r)
+
 		// Fetch inventory filtered by aisle filter
-		wl, err := FetchInventory(af)
+		wl, err := FetchInventory(r.Context(), af)
 		if err != nil {
 			log.Println(err)
 		}
-		// Send filtered inventory in json response
-		if err = jsonApi(w, r, wl, true); err != nil {
+		// Send filtered inventory in the requested response format
+		if err = writeResponse(w, r, wl, true); err != nil {
 			log.Println(err)
 		}
 	}
 }
 
-func handleApiDiscrepancies(w http.ResponseWriter, r *http.Request) {
+// handleApiDiscrepancies is wrapped with withRequestDeadline so a
+// request-scoped timeout actually bounds the underlying v_inventory query.
+var handleApiDiscrepancies = withRequestDeadline(handleApiDiscrepanciesImpl)
+
+func handleApiDiscrepanciesImpl(w http.ResponseWriter, r *http.Request) {
 	// Fetch inventory based on page controls
 	var af AisleFilter
 
@@ -184,14 +361,16 @@ func handleApiDiscrepancies(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	applyQueryFilters(&af, r)
+
 	// Fetch inventory filtered by aisle filter
-	wl, err := FetchInventory(af)
+	wl, err := FetchInventory(r.Context(), af)
 	if err != nil {
 		log.Println(err)
 	}
 
-	// Send filter inventory in json response
-	if err = jsonApi(w, r, wl, false); err != nil {
+	// Send filter inventory in the requested response format
+	if err = writeResponse(w, r, wl, false); err != nil {
 		log.Println(err)
 	}
 }
@@ -207,10 +386,12 @@ type aisleStats struct {
 
 type aisleStatsList []aisleStats
 
-func fetchAisleStats() (asl aisleStatsList, err error) {
+// fetchAisleStats performs a query on v_aisleStats and returns the results in
+// a aisleStatsList. The query is cancelled if ctx is done before it completes.
+func fetchAisleStats(ctx context.Context) (asl aisleStatsList, err error) {
 	// Execute database query
 	var rows *sql.Rows
-	if rows, err = db.Query("select distinct aisle, numberException, numberEmpty, numberOccupied, numberUnscanned, lastScanned from v_aisleStats"); err != nil {
+	if rows, err = db.QueryContext(ctx, "select distinct aisle, numberException, numberEmpty, numberOccupied, numberUnscanned, lastScanned from v_aisleStats"); err != nil {
 		return
 	}
 	defer rows.Close()
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// defaultPageSize is the number of rows returned per page when the caller
+// does not specify a pageSize.
+const defaultPageSize = 100
+
+// ndjsonChunkSize is the number of rows fetched from the cursor per flush
+// when streaming a bulk export.
+const ndjsonChunkSize = 500
+
+// InventoryCursor iterates over a v_inventory query's results without
+// materializing the entire result set in memory.
+type InventoryCursor struct {
+	rows *sql.Rows
+}
+
+// NewInventoryCursor executes af's query and returns a cursor over the
+// matching rows. The query is cancelled if ctx is done before it completes.
+func NewInventoryCursor(ctx context.Context, af AisleFilter) (*InventoryCursor, error) {
+	stmt, args := af.toSqlStmt()
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &InventoryCursor{rows: rows}, nil
+}
+
+// Next advances the cursor and scans the next record. It returns
+// sql.ErrNoRows once the cursor is exhausted.
+func (c *InventoryCursor) Next() (record Wms, err error) {
+	if !c.rows.Next() {
+		if err = c.rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return
+	}
+	err = c.rows.Scan(&record.Id, &record.StartTime, &record.StopTime, &record.SKU, &record.Aisle, &record.Block, &record.Slot, &record.Shelf, &record.DisplayName, &record.Discrepancy, &record.Image)
+	return
+}
+
+// Fetch reads up to n records from the cursor into a WmsList. It returns
+// fewer than n records, with a nil error, once the cursor is exhausted.
+func (c *InventoryCursor) Fetch(n int) (wl WmsList, err error) {
+	for i := 0; i < n; i++ {
+		var record Wms
+		record, err = c.Next()
+		if err == sql.ErrNoRows {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		wl = append(wl, record)
+	}
+	return
+}
+
+// Close releases the underlying database rows.
+func (c *InventoryCursor) Close() error {
+	return c.rows.Close()
+}
+
+// pageCursor is the opaque keyset cursor encoded into nextCursor: the
+// (aisle, block, slot) of the last row returned in a page.
+type pageCursor struct {
+	Aisle string `json:"aisle"`
+	Block string `json:"block"`
+	Slot  string `json:"slot"`
+}
+
+// inventoryPage is the response body for handleApiInventoryPage.
+type inventoryPage struct {
+	Items      WmsList `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// handleApiInventoryPage serves a single page of inventory rows using
+// keyset pagination on (aisle, block, slot), so warehouse UIs can scroll
+// through tens of thousands of slots without materializing everything in
+// memory. It is wrapped with withRequestDeadline so a request-scoped
+// timeout actually bounds the underlying query.
+var handleApiInventoryPage = withRequestDeadline(handleApiInventoryPageImpl)
+
+func handleApiInventoryPageImpl(w http.ResponseWriter, r *http.Request) {
+	var af AisleFilter
+	applyQueryFilters(&af, r)
+
+	pageSize := defaultPageSize
+	if v := r.URL.Query().Get("pageSize"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			pageSize = n
+		}
+	}
+
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var pc pageCursor
+		if err := json.Unmarshal([]byte(c), &pc); err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		af.AfterAisle, af.AfterBlock, af.AfterSlot = pc.Aisle, pc.Block, pc.Slot
+	}
+
+	cur, err := NewInventoryCursor(r.Context(), af)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close()
+
+	items, err := cur.Fetch(pageSize)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	page := inventoryPage{Items: items}
+	if len(items) == pageSize {
+		last := items[len(items)-1]
+		if b, err := json.Marshal(pageCursor{Aisle: last.Aisle, Block: last.Block, Slot: last.Slot}); err == nil {
+			page.NextCursor = string(b)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Println(err)
+	}
+}
+
+// handleApiInventoryExport streams the full filtered inventory result set as
+// newline-delimited JSON, flushing each chunk as it is read from the cursor
+// so bulk export clients don't have to wait for the whole query to finish.
+// It is wrapped with withRequestDeadline so a request-scoped timeout
+// actually bounds the underlying query.
+var handleApiInventoryExport = withRequestDeadline(handleApiInventoryExportImpl)
+
+func handleApiInventoryExportImpl(w http.ResponseWriter, r *http.Request) {
+	var af AisleFilter
+	applyQueryFilters(&af, r)
+
+	cur, err := NewInventoryCursor(r.Context(), af)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	enc := json.NewEncoder(w)
+	for {
+		chunk, err := cur.Fetch(ndjsonChunkSize)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for i := range chunk {
+			if err := enc.Encode(&chunk[i]); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(chunk) < ndjsonChunkSize {
+			return
+		}
+	}
+}
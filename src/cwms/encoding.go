@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+	"gopkg.in/yaml.v3"
+)
+
+// writeResponse picks a response encoder based on the request's ?format=
+// query parameter, falling back to the Accept header, and writes data in
+// that format. The default (json) keeps calling jsonApi so existing
+// clients see no change in behaviour; passing ?nullstyle=json opts a
+// caller into a proper JSON null for SQL NULLs instead of the historical
+// empty string.
+func writeResponse(w http.ResponseWriter, r *http.Request, data interface{}, indent bool) error {
+	switch negotiateFormat(r) {
+	case "xml":
+		w.Header().Set("Content-Type", "application/xml")
+		return xml.NewEncoder(w).Encode(toXMLDoc(data))
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		return gocsv.Marshal(data, w)
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		return writeNDJSON(w, data)
+	case "yaml":
+		w.Header().Set("Content-Type", "application/yaml")
+		return yaml.NewEncoder(w).Encode(data)
+	default:
+		if r.URL.Query().Get("nullstyle") == "json" {
+			if wl, ok := data.(WmsList); ok {
+				data = toNullableJSON(wl)
+			}
+		}
+		return jsonApi(w, r, data, indent)
+	}
+}
+
+// negotiateFormat picks a response format from the ?format= query
+// parameter, falling back to the Accept header, and defaults to json.
+func negotiateFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f
+	}
+	switch accept := r.Header.Get("Accept"); {
+	case strings.Contains(accept, "application/xml"):
+		return "xml"
+	case strings.Contains(accept, "text/csv"):
+		return "csv"
+	case strings.Contains(accept, "application/x-ndjson"):
+		return "ndjson"
+	case strings.Contains(accept, "application/yaml"):
+		return "yaml"
+	default:
+		return "json"
+	}
+}
+
+// writeNDJSON renders data as newline-delimited JSON: one line per element
+// when data is a slice, or a single line otherwise.
+func writeNDJSON(w http.ResponseWriter, data interface{}) error {
+	v := reflect.ValueOf(data)
+	enc := json.NewEncoder(w)
+	if v.Kind() != reflect.Slice {
+		return enc.Encode(data)
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wmsXMLDoc wraps a WmsList in a named root element. encoding/xml has no
+// default root for a bare slice, so marshalling one directly produces a
+// sequence of sibling <Wms> elements with no enclosing tag, which is not
+// well-formed XML.
+type wmsXMLDoc struct {
+	XMLName xml.Name `xml:"items"`
+	Items   []Wms    `xml:"item"`
+}
+
+// aisleStatsXMLDoc wraps an aisleStatsList in a named root element, for the
+// same reason as wmsXMLDoc.
+type aisleStatsXMLDoc struct {
+	XMLName xml.Name     `xml:"items"`
+	Items   []aisleStats `xml:"item"`
+}
+
+// toXMLDoc wraps data in a named root element when it's one of the list
+// types the API handlers return, so xml.Encoder produces well-formed XML
+// instead of a bare sequence of sibling elements. Other values are passed
+// through unchanged.
+func toXMLDoc(data interface{}) interface{} {
+	switch v := data.(type) {
+	case WmsList:
+		return wmsXMLDoc{Items: v}
+	case aisleStatsList:
+		return aisleStatsXMLDoc{Items: v}
+	default:
+		return data
+	}
+}
+
+// wmsJSON mirrors Wms but lets nullstyle=json swap in a real JSON null for
+// SQL NULLs without touching NullString.MarshalJSON's default behaviour,
+// which other clients still depend on.
+type wmsJSON struct {
+	Wms
+	SKU         *string `json:"sku"`
+	Discrepancy *string `json:"discrepancy"`
+	Image       *string `json:"image"`
+}
+
+// toNullableJSON converts wl to wmsJSON records that marshal SQL NULLs as
+// JSON null instead of "".
+func toNullableJSON(wl WmsList) []wmsJSON {
+	out := make([]wmsJSON, len(wl))
+	for i, rec := range wl {
+		out[i] = wmsJSON{Wms: rec}
+		if rec.SKU.Valid {
+			out[i].SKU = &rec.SKU.String
+		}
+		if rec.Discrepancy.Valid {
+			out[i].Discrepancy = &rec.Discrepancy.String
+		}
+		if rec.Image.Valid {
+			out[i].Image = &rec.Image.String
+		}
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// fetchDiscrepancyCounts performs a query on v_inventory and returns the number
+// of discrepancy records grouped by sku. The query is cancelled if ctx is
+// done before it completes.
+func fetchDiscrepancyCounts(ctx context.Context) (counts map[string]int, err error) {
+	counts = make(map[string]int)
+
+	var rows *sql.Rows
+	rows, err = db.QueryContext(ctx, `select sku, count(*) from v_inventory where discrepancy != "" group by sku`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var sku string
+	var n int
+	for rows.Next() {
+		if err = rows.Scan(&sku, &n); err != nil {
+			return
+		}
+		counts[sku] = n
+	}
+	return
+}
+
+// mysqlDatetimeLayout is the text format the mysql driver returns for
+// v_aisleStats.lastScanned: unlike startTime/stopTime (scanned straight
+// into time.Time via the driver's DATETIME handling), lastScanned comes
+// back as a plain string, which happens when the view computes it
+// (e.g. via MAX()) rather than selecting a native DATETIME/TIMESTAMP
+// column directly.
+const mysqlDatetimeLayout = "2006-01-02 15:04:05"
+
+// parseLastScanned parses an aisleStats.LastScanned value, trying the
+// mysql driver's plain datetime format before falling back to RFC3339.
+func parseLastScanned(s string) (time.Time, error) {
+	if ts, err := time.Parse(mysqlDatetimeLayout, s); err == nil {
+		return ts, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// handleApiMetrics exposes aisleStats and discrepancy counts in Prometheus
+// text exposition format so the WMS backend can be scraped directly. It is
+// wrapped with withRequestDeadline so a request-scoped timeout actually
+// bounds the underlying queries.
+var handleApiMetrics = withRequestDeadline(handleApiMetricsImpl)
+
+func handleApiMetricsImpl(w http.ResponseWriter, r *http.Request) {
+	asl, err := fetchAisleStats(r.Context())
+	if err != nil {
+		log.Println(err)
+	}
+
+	dc, err := fetchDiscrepancyCounts(r.Context())
+	if err != nil {
+		log.Println(err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP corvus_inventory_slots_total Number of inventory slots by state")
+	fmt.Fprintln(w, "# TYPE corvus_inventory_slots_total gauge")
+	for _, as := range asl {
+		fmt.Fprintf(w, "corvus_inventory_slots_total{aisle=%q,state=\"occupied\"} %d\n", as.Id, as.NumberOccupied)
+		fmt.Fprintf(w, "corvus_inventory_slots_total{aisle=%q,state=\"empty\"} %d\n", as.Id, as.NumberEmpty)
+		fmt.Fprintf(w, "corvus_inventory_slots_total{aisle=%q,state=\"exception\"} %d\n", as.Id, as.NumberException)
+		fmt.Fprintf(w, "corvus_inventory_slots_total{aisle=%q,state=\"unscanned\"} %d\n", as.Id, as.NumberUnscanned)
+	}
+
+	fmt.Fprintln(w, "# HELP corvus_inventory_last_scanned_timestamp_seconds Unix timestamp of the last scan for an aisle")
+	fmt.Fprintln(w, "# TYPE corvus_inventory_last_scanned_timestamp_seconds gauge")
+	for _, as := range asl {
+		ts, err := parseLastScanned(as.LastScanned)
+		if err != nil {
+			log.Printf("metrics: aisle %q: %v", as.Id, err)
+			continue
+		}
+		fmt.Fprintf(w, "corvus_inventory_last_scanned_timestamp_seconds{aisle=%q} %d\n", as.Id, ts.Unix())
+	}
+
+	fmt.Fprintln(w, "# HELP corvus_inventory_discrepancies_total Number of discrepancy records by sku")
+	fmt.Fprintln(w, "# TYPE corvus_inventory_discrepancies_total gauge")
+	for sku, n := range dc {
+		fmt.Fprintf(w, "corvus_inventory_discrepancies_total{sku=%q} %d\n", sku, n)
+	}
+}
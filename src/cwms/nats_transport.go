@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/marswargod/corvus_backend/src/cwms/transport/nats"
+)
+
+// startNatsTransport subscribes the existing fetch functions on
+// "corvus.inventory.get", "corvus.aisles.list" and "corvus.aisles.stats" so
+// other services in the mesh can query inventory without going through HTTP.
+// The NATS URL and subject prefix are read from CORVUS_NATS_URL and
+// CORVUS_NATS_SUBJECT_PREFIX.
+func startNatsTransport() (*nats.Service, error) {
+	cfg := nats.Config{
+		URL:           envOrDefault("CORVUS_NATS_URL", "nats://127.0.0.1:4222"),
+		SubjectPrefix: envOrDefault("CORVUS_NATS_SUBJECT_PREFIX", "corvus"),
+	}
+
+	svc, err := nats.NewService(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := svc.Subscribe("inventory.get", func(req json.RawMessage) (interface{}, error) {
+		var af AisleFilter
+		if err := json.Unmarshal(req, &af); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), defaultQueryTimeout)
+		defer cancel()
+		return FetchInventory(ctx, af)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := svc.Subscribe("aisles.list", func(req json.RawMessage) (interface{}, error) {
+		var af AisleFilter
+		if err := json.Unmarshal(req, &af); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), defaultQueryTimeout)
+		defer cancel()
+		return fetchAisles(ctx, af.Aisle)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := svc.Subscribe("aisles.stats", func(req json.RawMessage) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultQueryTimeout)
+		defer cancel()
+		return fetchAisleStats(ctx)
+	}); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// envOrDefault returns the value of the named environment variable, or def
+// if it is unset or empty.
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
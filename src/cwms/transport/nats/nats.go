@@ -0,0 +1,67 @@
+// Package nats provides a request/reply transport that exposes existing
+// inventory fetch functions over NATS subjects, mirroring the HTTP handlers
+// in package main without depending on any of their types.
+package nats
+
+import (
+	"encoding/json"
+	"log"
+
+	natsio "github.com/nats-io/nats.go"
+)
+
+// Handler decodes a NATS request payload, invokes the underlying fetch
+// function, and returns the value to be marshalled back to the caller.
+type Handler func(req json.RawMessage) (interface{}, error)
+
+// Config holds the NATS connection settings for the inventory service.
+type Config struct {
+	URL           string // NATS server URL, e.g. "nats://127.0.0.1:4222"
+	SubjectPrefix string // Subject prefix, e.g. "corvus"
+}
+
+// Service subscribes to inventory-related subjects and dispatches incoming
+// requests to the registered handlers.
+type Service struct {
+	cfg  Config
+	conn *natsio.Conn
+}
+
+// NewService connects to the NATS server described by cfg.
+func NewService(cfg Config) (*Service, error) {
+	conn, err := natsio.Connect(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{cfg: cfg, conn: conn}, nil
+}
+
+// Subscribe registers handler to reply to request/reply messages on
+// "<SubjectPrefix>.<suffix>", e.g. "corvus.inventory.get".
+func (s *Service) Subscribe(suffix string, handler Handler) error {
+	subject := s.cfg.SubjectPrefix + "." + suffix
+	_, err := s.conn.Subscribe(subject, func(msg *natsio.Msg) {
+		resp, err := handler(msg.Data)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := msg.Respond(b); err != nil {
+			log.Println(err)
+		}
+	})
+	return err
+}
+
+// Close drains and closes the underlying NATS connection: in-flight
+// subscription callbacks and replies are flushed before the connection is
+// torn down, so a shutdown doesn't drop a request that's already being
+// handled.
+func (s *Service) Close() error {
+	return s.conn.Drain()
+}